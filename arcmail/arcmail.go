@@ -0,0 +1,153 @@
+// Package arcmail implements FTS-0004 ARCmail bundling: packing a .pkt into
+// the classic ".mo0"-".su6" bundle naming convention (optionally inside a
+// compressed container) and writing the .flo attach files a mailer reads to
+// find outbound bundles.
+package arcmail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/undead-ru/gofido"
+)
+
+// Flavor is the urgency/routing a bundle or netmail is queued with.
+type Flavor int
+
+const (
+	// Normal is ordinary store-and-forward mail.
+	Normal Flavor = iota
+	// Hold queues mail for the remote to poll for, rather than sending it.
+	Hold
+	// Crash sends mail immediately, bypassing the normal polling schedule.
+	Crash
+	// Direct sends mail only over a direct (non-routed) connection.
+	Direct
+	// Immediate is like Crash but also pre-empts any mail already in transit.
+	Immediate
+)
+
+func (f Flavor) String() string {
+	switch f {
+	case Normal:
+		return "Normal"
+	case Hold:
+		return "Hold"
+	case Crash:
+		return "Crash"
+	case Direct:
+		return "Direct"
+	case Immediate:
+		return "Immediate"
+	default:
+		return fmt.Sprintf("Flavor(%d)", int(f))
+	}
+}
+
+// floExt is the .flo/.?lo extension for a Flavor, per the classic attach
+// file naming convention.
+func (f Flavor) floExt() string {
+	switch f {
+	case Crash:
+		return "clo"
+	case Hold:
+		return "hlo"
+	case Direct:
+		return "dlo"
+	case Immediate:
+		return "ilo"
+	default:
+		return "flo"
+	}
+}
+
+// dayLetters maps time.Weekday (Sunday == 0) to the two-letter bundle
+// extension prefix FTS-0004 uses.
+var dayLetters = [7]string{"su", "mo", "tu", "we", "th", "fr", "sa"}
+
+func bundleBaseName(to gofido.FidoNetAddress) string {
+	return fmt.Sprintf("%04x%04x", to.Network, to.Node)
+}
+
+// OutboundBundleName returns the canonical ".mo0"-".su6" bundle name for
+// mail from `from` to `to` created on `day`, as sequence 0. Callers
+// creating more than one bundle to the same node on the same day should use
+// NextOutboundBundleName to find a free sequence digit instead.
+func OutboundBundleName(from, to gofido.FidoNetAddress, flavor Flavor, day time.Time) string {
+	return bundleBaseName(to) + "." + dayLetters[int(day.Weekday())] + "0"
+}
+
+// NextOutboundBundleName returns the first ".mo0"-".su6" bundle name for
+// `to` on `day` that doesn't already exist in dir.
+func NextOutboundBundleName(dir string, from, to gofido.FidoNetAddress, flavor Flavor, day time.Time) (string, error) {
+	base := bundleBaseName(to)
+	prefix := dayLetters[int(day.Weekday())]
+	for seq := 0; seq < 10; seq++ {
+		name := base + "." + prefix + strconv.Itoa(seq)
+		if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("arcmail: no free bundle name for %s on %s", base, prefix)
+}
+
+// FloFileName returns the .flo-family attach file name for mail queued to
+// `to` with the given Flavor.
+func FloFileName(to gofido.FidoNetAddress, flavor Flavor) string {
+	return bundleBaseName(to) + "." + flavor.floExt()
+}
+
+// PackBundle writes pktHead/pktPassword/messages as a .pkt, encoded with
+// charset, and wraps it with compressor (pass nil to write a bare,
+// uncompressed .pkt bundle).
+func PackBundle(w io.Writer, pktHead gofido.PktHeader, pktPassword string, messages []gofido.FidoMessage, variant gofido.PacketVariant, charset gofido.Charset, compressor Compressor) error {
+	var pktBuf bytes.Buffer
+	if err := gofido.PktWrite(&pktBuf, pktHead, pktPassword, messages, variant, charset); err != nil {
+		return fmt.Errorf("arcmail: packing .pkt: %w", err)
+	}
+	if compressor == nil {
+		_, err := w.Write(pktBuf.Bytes())
+		return err
+	}
+	return compressor.Pack(w, pktBuf.Bytes())
+}
+
+// UnpackBundle reads a bundle from r, detecting any compressed container by
+// its magic bytes, and decodes the .pkt within it.
+func UnpackBundle(r io.Reader) (pktHead gofido.PktHeader, pktPassword string, messages []gofido.FidoMessage, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return pktHead, "", nil, fmt.Errorf("arcmail: reading bundle: %w", err)
+	}
+
+	pktData := data
+	if compressor, ok := DetectCompressor(data); ok {
+		if pktData, err = compressor.Unpack(bytes.NewReader(data)); err != nil {
+			return pktHead, "", nil, fmt.Errorf("arcmail: unpacking %s bundle: %w", compressor.Name(), err)
+		}
+	} else if name, ok := IdentifyContainer(data); ok {
+		return pktHead, "", nil, fmt.Errorf("arcmail: bundle is a %s container, which isn't supported", name)
+	}
+
+	return gofido.PktRead(bytes.NewReader(pktData))
+}
+
+// AppendFloEntry appends bundlePath to the .flo attach file at floPath,
+// creating it if necessary, so a mailer picks the bundle up on its next
+// poll of that file.
+func AppendFloEntry(floPath string, bundlePath string) error {
+	f, err := os.OpenFile(floPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("arcmail: opening %s: %w", floPath, err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(bundlePath + "\n"); err != nil {
+		return fmt.Errorf("arcmail: writing %s: %w", floPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package arcmail
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Compressor packs a .pkt's bytes into a compressed container (and back),
+// for one of the classic FTS-0004 archive flavours.
+type Compressor interface {
+	// Name identifies the container format, e.g. "ZIP".
+	Name() string
+	// Magic is the byte signature DetectCompressor matches at the start of
+	// a bundle to recognise this format.
+	Magic() []byte
+	// Pack writes pktData to w as a single-entry archive in this format.
+	Pack(w io.Writer, pktData []byte) error
+	// Unpack extracts and returns the .pkt payload from r.
+	Unpack(r io.Reader) ([]byte, error)
+}
+
+var compressors []Compressor
+
+// RegisterCompressor adds c to the set DetectCompressor considers. Call it
+// from an init func to plug in a new container format that can actually
+// Pack and Unpack, not merely recognise by magic bytes - see
+// containerSignatures for detection-only formats.
+func RegisterCompressor(c Compressor) {
+	compressors = append(compressors, c)
+}
+
+// detectWindow is how many leading bytes DetectCompressor and
+// IdentifyContainer search for a magic signature in - enough to cover
+// formats like LHA whose signature sits a few header bytes in, rather than
+// at offset 0.
+const detectWindow = 8
+
+// DetectCompressor returns the registered Compressor whose Magic appears
+// within the first few bytes of data, if any.
+func DetectCompressor(data []byte) (Compressor, bool) {
+	window := data
+	if len(window) > detectWindow {
+		window = window[:detectWindow]
+	}
+	for _, c := range compressors {
+		if bytes.Contains(window, c.Magic()) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// containerSignature names a container format gofido can recognise by
+// magic bytes but doesn't implement Pack/Unpack for.
+type containerSignature struct {
+	name  string
+	magic []byte
+}
+
+var containerSignatures = []containerSignature{
+	{"ARJ", []byte{0x60, 0xEA}},
+	{"LHA", []byte("-lh")},
+	{"ZOO", []byte("ZOO ")},
+}
+
+// IdentifyContainer names the container format data appears to be in, even
+// when gofido has no Compressor that can actually unpack it - so a caller
+// gets "this is an ARJ bundle" instead of an opaque decode failure.
+func IdentifyContainer(data []byte) (name string, ok bool) {
+	if c, found := DetectCompressor(data); found {
+		return c.Name(), true
+	}
+	window := data
+	if len(window) > detectWindow {
+		window = window[:detectWindow]
+	}
+	for _, sig := range containerSignatures {
+		if bytes.Contains(window, sig.magic) {
+			return sig.name, true
+		}
+	}
+	return "", false
+}
+
+func init() {
+	RegisterCompressor(zipCompressor{})
+}
+
+// zipCompressor wraps a .pkt in a single-entry ZIP archive, the most common
+// ARCmail container in modern use.
+type zipCompressor struct{}
+
+func (zipCompressor) Name() string  { return "ZIP" }
+func (zipCompressor) Magic() []byte { return []byte{0x50, 0x4B, 0x03, 0x04} }
+
+func (zipCompressor) Pack(w io.Writer, pktData []byte) error {
+	zw := zip.NewWriter(w)
+	entry, err := zw.Create("bundle.pkt")
+	if err != nil {
+		return fmt.Errorf("arcmail: creating zip entry: %w", err)
+	}
+	if _, err = entry.Write(pktData); err != nil {
+		return fmt.Errorf("arcmail: writing zip entry: %w", err)
+	}
+	return zw.Close()
+}
+
+func (zipCompressor) Unpack(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("arcmail: reading zip bundle: %w", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("arcmail: opening zip bundle: %w", err)
+	}
+	if len(zr.File) == 0 {
+		return nil, fmt.Errorf("arcmail: zip bundle is empty")
+	}
+	f, err := zr.File[0].Open()
+	if err != nil {
+		return nil, fmt.Errorf("arcmail: opening zip entry %s: %w", zr.File[0].Name, err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
@@ -0,0 +1,50 @@
+package arcmail
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestZipCompressorPackUnpackRoundTrip(t *testing.T) {
+	pktData := []byte("not really a .pkt, just some bytes to carry")
+
+	var buf bytes.Buffer
+	if err := (zipCompressor{}).Pack(&buf, pktData); err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+
+	c, ok := DetectCompressor(buf.Bytes())
+	if !ok || c.Name() != "ZIP" {
+		t.Fatalf("DetectCompressor = %v, %v, want ZIP", c, ok)
+	}
+
+	got, err := c.Unpack(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+	if !bytes.Equal(got, pktData) {
+		t.Errorf("Unpack = %q, want %q", got, pktData)
+	}
+}
+
+func TestIdentifyContainerRecognisesUnsupportedFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"ARJ", []byte{0x60, 0xEA, 0, 0, 0, 0, 0, 0}},
+		{"LHA", []byte("-lh5-xxxxx")},
+		{"ZOO", []byte("ZOO \x01\x02\x03")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := DetectCompressor(tt.data); ok {
+				t.Fatalf("%s magic unexpectedly matched a registered Compressor", tt.name)
+			}
+			name, ok := IdentifyContainer(tt.data)
+			if !ok || name != tt.name {
+				t.Errorf("IdentifyContainer = %q, %v, want %q, true", name, ok, tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package binkp
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// cramOptPrefix is how a CRAM-MD5 challenge is carried inside a "M_NUL OPT"
+// line, per FTA-1027.
+const cramOptPrefix = "OPT CRAM-MD5-"
+
+// cramPwdPrefix marks a M_PWD argument as a CRAM-MD5 response rather than a
+// plaintext password.
+const cramPwdPrefix = "CRAM-MD5-"
+
+// generateChallenge returns a random hex-encoded CRAM-MD5 challenge.
+func generateChallenge() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("binkp: generating CRAM-MD5 challenge: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// cramResponse computes the FTA-1027 CRAM-MD5 response to challengeHex for
+// password, formatted ready to send as a M_PWD argument.
+func cramResponse(challengeHex, password string) (string, error) {
+	challenge, err := hex.DecodeString(challengeHex)
+	if err != nil {
+		return "", fmt.Errorf("binkp: decoding CRAM-MD5 challenge: %w", err)
+	}
+	mac := hmac.New(md5.New, []byte(password))
+	mac.Write(challenge)
+	return cramPwdPrefix + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyCramResponse checks a M_PWD argument of the form "CRAM-MD5-<hex>"
+// against challengeHex and password.
+func verifyCramResponse(pwdArg, challengeHex, password string) (bool, error) {
+	want, err := cramResponse(challengeHex, password)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal([]byte(pwdArg), []byte(want)), nil
+}
@@ -0,0 +1,121 @@
+// Package binkp implements the FTS-1026 BinkP/1.0 mail transport protocol
+// over TCP, including the FTA-1027 CRAM-MD5 authentication extension.
+package binkp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxFrameSize is the largest payload FTS-1026 allows in a single frame.
+const MaxFrameSize = 32767
+
+// Command identifies a BinkP M_* command frame.
+type Command byte
+
+// BinkP commands, per FTS-1026 section 3.
+const (
+	CmdNUL  Command = 0
+	CmdADR  Command = 1
+	CmdPWD  Command = 2
+	CmdFILE Command = 3
+	CmdOK   Command = 4
+	CmdEOB  Command = 5
+	CmdGOT  Command = 6
+	CmdERR  Command = 7
+	CmdBSY  Command = 8
+	CmdGET  Command = 9
+	CmdSKIP Command = 10
+)
+
+func (c Command) String() string {
+	switch c {
+	case CmdNUL:
+		return "M_NUL"
+	case CmdADR:
+		return "M_ADR"
+	case CmdPWD:
+		return "M_PWD"
+	case CmdFILE:
+		return "M_FILE"
+	case CmdOK:
+		return "M_OK"
+	case CmdEOB:
+		return "M_EOB"
+	case CmdGOT:
+		return "M_GOT"
+	case CmdERR:
+		return "M_ERR"
+	case CmdBSY:
+		return "M_BSY"
+	case CmdGET:
+		return "M_GET"
+	case CmdSKIP:
+		return "M_SKIP"
+	default:
+		return fmt.Sprintf("Command(%d)", int(c))
+	}
+}
+
+// frame is one FTS-1026 frame as it appears on the wire: a command frame
+// (top header bit set, payload is a Command byte plus an argument string)
+// or a data frame (payload is raw file bytes).
+type frame struct {
+	isCommand bool
+	command   Command
+	arg       string
+	data      []byte
+}
+
+// writeCommandFrame writes a command frame with the given argument string.
+func writeCommandFrame(w io.Writer, cmd Command, arg string) error {
+	payload := append([]byte{byte(cmd)}, []byte(arg)...)
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("binkp: %s argument too large: %d bytes", cmd, len(payload))
+	}
+	header := uint16(len(payload)) | 0x8000
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return fmt.Errorf("binkp: writing %s frame header: %w", cmd, err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		return fmt.Errorf("binkp: writing %s frame payload: %w", cmd, err)
+	}
+	return nil
+}
+
+// writeDataFrame writes a raw data frame, at most MaxFrameSize bytes.
+func writeDataFrame(w io.Writer, data []byte) error {
+	if len(data) > MaxFrameSize {
+		return fmt.Errorf("binkp: data frame too large: %d bytes", len(data))
+	}
+	header := uint16(len(data))
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return fmt.Errorf("binkp: writing data frame header: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("binkp: writing data frame payload: %w", err)
+	}
+	return nil
+}
+
+// readFrame reads and decodes the next frame from r.
+func readFrame(r io.Reader) (frame, error) {
+	var header uint16
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return frame{}, fmt.Errorf("binkp: reading frame header: %w", err)
+	}
+	isCommand := header&0x8000 != 0
+	length := header &^ 0x8000
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return frame{}, fmt.Errorf("binkp: reading frame payload: %w", err)
+	}
+	if !isCommand {
+		return frame{data: payload}, nil
+	}
+	if len(payload) == 0 {
+		return frame{}, fmt.Errorf("binkp: empty command frame")
+	}
+	return frame{isCommand: true, command: Command(payload[0]), arg: string(payload[1:])}, nil
+}
@@ -0,0 +1,455 @@
+package binkp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/undead-ru/gofido"
+)
+
+// InboundHandler receives .pkt bundles as they arrive over a Session.
+type InboundHandler interface {
+	// HandleBundle is called once per received .pkt file, already decoded
+	// by gofido.PktRead.
+	HandleBundle(header gofido.PktHeader, messages []gofido.FidoMessage) error
+}
+
+// Session is one BinkP connection, after a successful handshake. Once
+// created, a single background pump goroutine owns every read from the
+// underlying connection; SendPkt and Run both wait on it rather than
+// reading the connection themselves, so a peer that starts sending its own
+// bundle while we're still waiting for an M_GOT isn't silently dropped.
+type Session struct {
+	conn net.Conn
+
+	ourAddr  gofido.FidoNetAddress
+	password string
+	handler  InboundHandler
+
+	TheirAddrs    []gofido.FidoNetAddress
+	TheirSystem   string
+	TheirSysop    string
+	TheirLocation string
+
+	pumpOnce sync.Once
+	done     chan struct{} // closed when the pump goroutine exits
+
+	mu   sync.Mutex
+	err  error // first error the pump encountered, if any
+	acks map[string]chan ackResult
+}
+
+// ackResult is delivered to a SendPkt call once the pump sees the matching
+// M_GOT or M_SKIP frame.
+type ackResult struct {
+	skipped bool
+}
+
+// Dial connects to addr, performs the BinkP client handshake authenticating
+// as ourAddr with password, and returns the ready-to-use Session. Bundles
+// the peer sends are decoded and passed to handler, which may be nil to
+// ignore them.
+func Dial(addr string, ourAddr gofido.FidoNetAddress, password string, handler InboundHandler) (*Session, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("binkp: dialing %s: %w", addr, err)
+	}
+	s := &Session{conn: conn, ourAddr: ourAddr, password: password, handler: handler}
+	if err := s.clientHandshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// ListenAndServe accepts BinkP connections on addr, authenticates each one
+// as ourAddr with password, and hands received .pkt bundles to handler.
+func ListenAndServe(addr string, ourAddr gofido.FidoNetAddress, password string, handler InboundHandler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("binkp: listening on %s: %w", addr, err)
+	}
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("binkp: accepting connection: %w", err)
+		}
+		go func() {
+			defer conn.Close()
+			s := &Session{conn: conn, ourAddr: ourAddr, password: password, handler: handler}
+			if err := s.serverHandshake(); err != nil {
+				writeCommandFrame(conn, CmdERR, err.Error())
+				return
+			}
+			s.Run()
+		}()
+	}
+}
+
+func greetingFrames(ourAddr gofido.FidoNetAddress) map[string]string {
+	return map[string]string{
+		"SYS":  "gofido node " + gofido.ComposeAddress(ourAddr),
+		"ZYZ":  "sysop",
+		"LOC":  "unknown",
+		"NDL":  "TCP,,500",
+		"TIME": time.Now().Format(time.RFC1123Z),
+		"VER":  "gofido/binkp FTS-1026",
+	}
+}
+
+func writeGreeting(conn net.Conn, ourAddr gofido.FidoNetAddress) error {
+	for _, key := range []string{"SYS", "ZYZ", "LOC", "NDL", "TIME", "VER"} {
+		if err := writeCommandFrame(conn, CmdNUL, key+" "+greetingFrames(ourAddr)[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readUntilAddr reads M_NUL lines (recording their values into s) until it
+// sees the peer's M_ADR frame, whose addresses it parses into s.TheirAddrs.
+// It returns any CRAM-MD5 challenge the peer advertised via
+// "M_NUL OPT CRAM-MD5-...".
+func (s *Session) readUntilAddr() (challenge string, err error) {
+	for {
+		f, err := readFrame(s.conn)
+		if err != nil {
+			return "", err
+		}
+		switch f.command {
+		case CmdNUL:
+			if strings.HasPrefix(f.arg, cramOptPrefix) {
+				challenge = strings.TrimPrefix(f.arg, cramOptPrefix)
+			}
+			if strings.HasPrefix(f.arg, "SYS ") {
+				s.TheirSystem = strings.TrimPrefix(f.arg, "SYS ")
+			}
+			if strings.HasPrefix(f.arg, "ZYZ ") {
+				s.TheirSysop = strings.TrimPrefix(f.arg, "ZYZ ")
+			}
+			if strings.HasPrefix(f.arg, "LOC ") {
+				s.TheirLocation = strings.TrimPrefix(f.arg, "LOC ")
+			}
+		case CmdADR:
+			for _, a := range strings.Fields(f.arg) {
+				addr, perr := gofido.ParseAddress(a)
+				if perr != nil {
+					return challenge, fmt.Errorf("binkp: parsing address %q: %w", a, perr)
+				}
+				s.TheirAddrs = append(s.TheirAddrs, addr)
+			}
+			return challenge, nil
+		case CmdERR:
+			return "", fmt.Errorf("binkp: peer error: %s", f.arg)
+		case CmdBSY:
+			return "", fmt.Errorf("binkp: peer busy: %s", f.arg)
+		}
+	}
+}
+
+// clientHandshake implements the calling side of the FTS-1026 handshake.
+func (s *Session) clientHandshake() error {
+	if err := writeGreeting(s.conn, s.ourAddr); err != nil {
+		return err
+	}
+	if err := writeCommandFrame(s.conn, CmdADR, gofido.ComposeAddress(s.ourAddr)); err != nil {
+		return err
+	}
+
+	challenge, err := s.readUntilAddr()
+	if err != nil {
+		return err
+	}
+
+	if challenge != "" {
+		resp, err := cramResponse(challenge, s.password)
+		if err != nil {
+			return err
+		}
+		if err := writeCommandFrame(s.conn, CmdPWD, resp); err != nil {
+			return err
+		}
+	} else if s.password != "" {
+		if err := writeCommandFrame(s.conn, CmdPWD, s.password); err != nil {
+			return err
+		}
+	}
+
+	f, err := readFrame(s.conn)
+	if err != nil {
+		return err
+	}
+	switch f.command {
+	case CmdOK:
+		return nil
+	case CmdERR:
+		return fmt.Errorf("binkp: handshake rejected: %s", f.arg)
+	default:
+		return fmt.Errorf("binkp: unexpected frame %s during handshake", f.command)
+	}
+}
+
+// serverHandshake implements the answering side of the FTS-1026 handshake,
+// challenging the caller with CRAM-MD5 when a password is configured.
+func (s *Session) serverHandshake() error {
+	var challenge string
+	if s.password != "" {
+		var err error
+		if challenge, err = generateChallenge(); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range []string{"SYS", "ZYZ", "LOC", "NDL", "TIME", "VER"} {
+		if err := writeCommandFrame(s.conn, CmdNUL, key+" "+greetingFrames(s.ourAddr)[key]); err != nil {
+			return err
+		}
+	}
+	if challenge != "" {
+		if err := writeCommandFrame(s.conn, CmdNUL, cramOptPrefix+challenge); err != nil {
+			return err
+		}
+	}
+	if err := writeCommandFrame(s.conn, CmdADR, gofido.ComposeAddress(s.ourAddr)); err != nil {
+		return err
+	}
+
+	if _, err := s.readUntilAddr(); err != nil {
+		return err
+	}
+
+	f, err := readFrame(s.conn)
+	if err != nil {
+		return err
+	}
+	if f.command != CmdPWD {
+		return fmt.Errorf("binkp: expected M_PWD, got %s", f.command)
+	}
+
+	var ok bool
+	if challenge != "" {
+		if ok, err = verifyCramResponse(f.arg, challenge, s.password); err != nil {
+			return err
+		}
+	} else {
+		ok = f.arg == s.password
+	}
+	if !ok {
+		writeCommandFrame(s.conn, CmdERR, "bad password")
+		return fmt.Errorf("binkp: password rejected for %v", s.TheirAddrs)
+	}
+	return writeCommandFrame(s.conn, CmdOK, "")
+}
+
+// startPump launches the session's single background reader, if it isn't
+// already running. Safe to call from both SendPkt and Run, in either order.
+func (s *Session) startPump() {
+	s.pumpOnce.Do(func() {
+		s.done = make(chan struct{})
+		go s.pump()
+	})
+}
+
+// pump is the sole reader of s.conn for the lifetime of the Session: it
+// assembles inbound file transfers and dispatches M_GOT/M_SKIP to whichever
+// SendPkt call is waiting for them. M_EOB only means the peer has no more
+// files to originate - they may still be waiting to M_GOT/M_SKIP something
+// we're sending - so pump keeps reading after it until every outstanding
+// SendPkt of ours has been resolved.
+func (s *Session) pump() {
+	defer close(s.done)
+
+	var curName string
+	var curSize int64
+	var curBuf bytes.Buffer
+	peerEOB := false
+
+	for {
+		if peerEOB {
+			s.mu.Lock()
+			noPending := len(s.acks) == 0
+			s.mu.Unlock()
+			if noPending {
+				return
+			}
+		}
+
+		f, err := readFrame(s.conn)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+
+		if !f.isCommand {
+			curBuf.Write(f.data)
+			if int64(curBuf.Len()) >= curSize {
+				if err := s.finishInbound(curName, curBuf.Bytes()); err != nil {
+					s.fail(err)
+					return
+				}
+				curBuf.Reset()
+				curName, curSize = "", 0
+			}
+			continue
+		}
+
+		switch f.command {
+		case CmdFILE:
+			name, size, _, _, err := parseFileArg(f.arg)
+			if err != nil {
+				s.fail(err)
+				return
+			}
+			curName, curSize = name, size
+			curBuf.Reset()
+		case CmdGOT:
+			s.resolveAck(f.arg, false)
+		case CmdSKIP:
+			s.resolveAck(f.arg, true)
+		case CmdEOB:
+			peerEOB = true
+		case CmdERR:
+			s.fail(fmt.Errorf("binkp: peer error: %s", f.arg))
+			return
+		case CmdBSY:
+			s.fail(fmt.Errorf("binkp: peer busy: %s", f.arg))
+			return
+		}
+	}
+}
+
+// fail records err as the reason the pump stopped, for Run and any SendPkt
+// call still waiting on s.done to report.
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// resolveAck delivers an M_GOT/M_SKIP frame to the SendPkt call waiting on
+// the "name size" key it carries, if any is still waiting.
+func (s *Session) resolveAck(arg string, skipped bool) {
+	fields := strings.Fields(arg)
+	if len(fields) < 2 {
+		return
+	}
+	key := fields[0] + " " + fields[1]
+
+	s.mu.Lock()
+	ch, ok := s.acks[key]
+	if ok {
+		delete(s.acks, key)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		ch <- ackResult{skipped: skipped}
+	}
+}
+
+// SendPkt offers a .pkt bundle to the peer as a BinkP file transfer and
+// waits for it to be acknowledged with M_GOT. It shares the Session's
+// pump goroutine with Run, so a bundle the peer sends us while we're
+// waiting for our own acknowledgement is still assembled and delivered to
+// the handler instead of being discarded.
+func (s *Session) SendPkt(name string, data []byte, modTime time.Time) error {
+	s.startPump()
+
+	// The ack channel must be registered before a single frame goes out: the
+	// peer can reply with M_GOT as soon as it's seen the last data frame,
+	// and pump would silently drop that ack if it arrived before we were
+	// listening for it.
+	key := fmt.Sprintf("%s %d", name, len(data))
+	ack := make(chan ackResult, 1)
+	s.mu.Lock()
+	if s.acks == nil {
+		s.acks = make(map[string]chan ackResult)
+	}
+	s.acks[key] = ack
+	s.mu.Unlock()
+
+	arg := fmt.Sprintf("%s %d %d 0", name, len(data), modTime.Unix())
+	if err := writeCommandFrame(s.conn, CmdFILE, arg); err != nil {
+		return err
+	}
+	for offset := 0; offset < len(data); offset += MaxFrameSize {
+		end := offset + MaxFrameSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := writeDataFrame(s.conn, data[offset:end]); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case res := <-ack:
+		if res.skipped {
+			return fmt.Errorf("binkp: peer skipped %s", name)
+		}
+		return nil
+	case <-s.done:
+		s.mu.Lock()
+		err := s.err
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("binkp: session ended before %s was acknowledged", name)
+	}
+}
+
+// Run starts the pump if it isn't already running, announces end-of-batch
+// with M_EOB, and blocks until the peer does the same (or the pump fails),
+// decoding every received .pkt bundle with gofido.PktRead and passing it to
+// the Session's handler.
+func (s *Session) Run() error {
+	s.startPump()
+	if err := writeCommandFrame(s.conn, CmdEOB, ""); err != nil {
+		return err
+	}
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Session) finishInbound(name string, data []byte) error {
+	if err := writeCommandFrame(s.conn, CmdGOT, fmt.Sprintf("%s %d", name, len(data))); err != nil {
+		return err
+	}
+	if s.handler == nil {
+		return nil
+	}
+	header, _, messages, err := gofido.PktRead(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("binkp: decoding received bundle %s: %w", name, err)
+	}
+	return s.handler.HandleBundle(header, messages)
+}
+
+// parseFileArg parses a M_FILE argument: "name size unixtime offset".
+func parseFileArg(arg string) (name string, size int64, modTime time.Time, offset int64, err error) {
+	fields := strings.Fields(arg)
+	if len(fields) != 4 {
+		return "", 0, time.Time{}, 0, fmt.Errorf("binkp: malformed M_FILE argument %q", arg)
+	}
+	name = fields[0]
+	if size, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return
+	}
+	var unixTime int64
+	if unixTime, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return
+	}
+	modTime = time.Unix(unixTime, 0)
+	offset, err = strconv.ParseInt(fields[3], 10, 64)
+	return
+}
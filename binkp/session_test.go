@@ -0,0 +1,89 @@
+package binkp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/undead-ru/gofido"
+)
+
+type recordingHandler struct {
+	bundles [][]gofido.FidoMessage
+}
+
+func (h *recordingHandler) HandleBundle(hdr gofido.PktHeader, msgs []gofido.FidoMessage) error {
+	h.bundles = append(h.bundles, msgs)
+	return nil
+}
+
+func testPkt(t *testing.T, subj string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	head := gofido.PktHeader{OrigZone: 2, DestZone: 2}
+	msgs := []gofido.FidoMessage{{FromName: "A", ToName: "B", Subj: subj, Text: "hi\n"}}
+	if err := gofido.PktWrite(&buf, head, "", msgs, gofido.VariantType2, gofido.DefaultCharset); err != nil {
+		t.Fatalf("PktWrite: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestSendPktWhileReceiving sends one bundle from the client while the
+// server's pump, started by Run, is receiving: SendPkt must return once the
+// server answers M_GOT, and the bundle must reach the server's handler. The
+// server sends no bundle of its own here.
+func TestSendPktWhileReceiving(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	serverH := &recordingHandler{}
+	serverErrCh := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErrCh <- err
+			return
+		}
+		defer conn.Close()
+		s := &Session{conn: conn, ourAddr: gofido.FidoNetAddress{Zone: 2, Network: 1, Node: 1}, password: "test", handler: serverH}
+		if err := s.serverHandshake(); err != nil {
+			serverErrCh <- err
+			return
+		}
+		serverErrCh <- s.Run()
+	}()
+
+	clientH := &recordingHandler{}
+	sess, err := Dial(ln.Addr().String(), gofido.FidoNetAddress{Zone: 2, Network: 1, Node: 2}, "test", clientH)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer sess.conn.Close()
+
+	if err := sess.SendPkt("req1.pkt", testPkt(t, "client->server"), time.Now()); err != nil {
+		t.Fatalf("SendPkt: %v", err)
+	}
+	if err := sess.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	select {
+	case err := <-serverErrCh:
+		if err != nil {
+			t.Fatalf("server Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for server to finish")
+	}
+
+	if len(serverH.bundles) != 1 || len(serverH.bundles[0]) != 1 {
+		t.Fatalf("server received %d bundles, want 1 bundle of 1 message", len(serverH.bundles))
+	}
+	if serverH.bundles[0][0].Subj != "client->server" {
+		t.Errorf("server received subject %q, want %q", serverH.bundles[0][0].Subj, "client->server")
+	}
+}
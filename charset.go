@@ -0,0 +1,184 @@
+package gofido
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Charset converts message text between its on-the-wire encoding and Go's
+// native UTF-8 strings. PktScanner picks one per message from the CHRS:
+// kludge (falling back to DefaultCharset); PktWrite encodes with the
+// Charset it's given and emits a matching CHRS: kludge.
+type Charset interface {
+	// Name is the CHRS: kludge token identifying this charset, e.g. "CP866".
+	Name() string
+	// Level is the second CHRS: kludge field: a rough indicator of how far
+	// the charset strays from 7-bit ASCII (2 for single-byte 8-bit code
+	// pages, 4 for Unicode).
+	Level() int
+	// Decode converts src from this charset to UTF-8.
+	Decode(src []byte) ([]byte, error)
+	// Encode converts src (UTF-8) to this charset. If src contains a rune
+	// this charset can't represent, Encode returns a *CharsetError.
+	Encode(src []byte) ([]byte, error)
+}
+
+// CharsetError reports a rune that a Charset's Encode couldn't represent.
+type CharsetError struct {
+	Charset string // Charset.Name() that rejected the rune
+	Rune    rune   // the offending rune
+	Offset  int    // byte offset of the rune within the input passed to Encode
+}
+
+func (e *CharsetError) Error() string {
+	return fmt.Sprintf("gofido: rune %q (%U) at byte offset %d cannot be represented in %s",
+		e.Rune, e.Rune, e.Offset, e.Charset)
+}
+
+// charsets is the registry LookupCharset searches, keyed by Name.
+var charsets = make(map[string]Charset)
+
+// RegisterCharset adds cs to the set LookupCharset considers, keyed by
+// cs.Name(). Call it from an init func to plug in a new charset.
+func RegisterCharset(cs Charset) {
+	charsets[cs.Name()] = cs
+}
+
+// LookupCharset returns the registered Charset with the given CHRS: name,
+// if any.
+func LookupCharset(name string) (Charset, bool) {
+	cs, ok := charsets[name]
+	return cs, ok
+}
+
+// DefaultCharset is the Charset PktScanner falls back to for messages that
+// carry no CHRS: kludge, and the fallback every scanner from NewPktScanner
+// starts with.
+// Classic FidoNet traffic is almost always Russian-region CP866, so that's
+// the historical default here; callers can override it with
+// PktScanner.SetDefaultCharset.
+var DefaultCharset Charset = cp866Charset{}
+
+func init() {
+	RegisterCharset(cp866Charset{})
+	RegisterCharset(cp437Charset{})
+	RegisterCharset(latin1Charset{})
+	RegisterCharset(koi8rCharset{})
+	RegisterCharset(utf8Charset{})
+}
+
+// charmapCharset implements Charset over a golang.org/x/text/encoding
+// charmap.Charmap, encoding rune by rune so an unencodable rune can be
+// reported with its exact byte offset.
+type charmapCharset struct {
+	name  string
+	cm    *charmap.Charmap
+	level int
+}
+
+func (c charmapCharset) Name() string { return c.name }
+func (c charmapCharset) Level() int   { return c.level }
+
+func (c charmapCharset) Decode(src []byte) ([]byte, error) {
+	dec := c.cm.NewDecoder()
+	out := make([]byte, len(src)*utf8.UTFMax)
+	n, _, err := dec.Transform(out, src, true)
+	if err != nil {
+		return nil, fmt.Errorf("gofido: decoding %s: %w", c.name, err)
+	}
+	return out[:n], nil
+}
+
+func (c charmapCharset) Encode(src []byte) ([]byte, error) {
+	return encodeRuneWise(c.cm.NewEncoder(), c.name, src)
+}
+
+// encodeRuneWise feeds src to enc one rune at a time, so an unencodable rune
+// can be reported together with its byte offset in src rather than just
+// failing the whole buffer.
+func encodeRuneWise(enc *encoding.Encoder, name string, src []byte) ([]byte, error) {
+	out := make([]byte, 0, len(src))
+	buf := make([]byte, 8)
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRune(src[i:])
+		n, _, err := enc.Transform(buf, src[i:i+size], true)
+		if err != nil {
+			return nil, &CharsetError{Charset: name, Rune: r, Offset: i}
+		}
+		out = append(out, buf[:n]...)
+		i += size
+	}
+	return out, nil
+}
+
+type cp866Charset struct{}
+
+func (cp866Charset) Name() string { return "CP866" }
+func (cp866Charset) Level() int   { return 2 }
+func (cp866Charset) Decode(src []byte) ([]byte, error) {
+	return charmapCharset{name: "CP866", cm: charmap.CodePage866}.Decode(src)
+}
+func (cp866Charset) Encode(src []byte) ([]byte, error) {
+	return charmapCharset{name: "CP866", cm: charmap.CodePage866}.Encode(src)
+}
+
+type cp437Charset struct{}
+
+func (cp437Charset) Name() string { return "CP437" }
+func (cp437Charset) Level() int   { return 2 }
+func (cp437Charset) Decode(src []byte) ([]byte, error) {
+	return charmapCharset{name: "CP437", cm: charmap.CodePage437}.Decode(src)
+}
+func (cp437Charset) Encode(src []byte) ([]byte, error) {
+	return charmapCharset{name: "CP437", cm: charmap.CodePage437}.Encode(src)
+}
+
+type latin1Charset struct{}
+
+func (latin1Charset) Name() string { return "LATIN-1" }
+func (latin1Charset) Level() int   { return 2 }
+func (latin1Charset) Decode(src []byte) ([]byte, error) {
+	return charmapCharset{name: "LATIN-1", cm: charmap.ISO8859_1}.Decode(src)
+}
+func (latin1Charset) Encode(src []byte) ([]byte, error) {
+	return charmapCharset{name: "LATIN-1", cm: charmap.ISO8859_1}.Encode(src)
+}
+
+type koi8rCharset struct{}
+
+func (koi8rCharset) Name() string { return "KOI8-R" }
+func (koi8rCharset) Level() int   { return 2 }
+func (koi8rCharset) Decode(src []byte) ([]byte, error) {
+	return charmapCharset{name: "KOI8-R", cm: charmap.KOI8R}.Decode(src)
+}
+func (koi8rCharset) Encode(src []byte) ([]byte, error) {
+	return charmapCharset{name: "KOI8-R", cm: charmap.KOI8R}.Encode(src)
+}
+
+// utf8Charset is the identity charset: .pkt bodies already travel as UTF-8
+// once decoded, so there's nothing to transcode, only to validate.
+type utf8Charset struct{}
+
+func (utf8Charset) Name() string { return "UTF-8" }
+func (utf8Charset) Level() int   { return 4 }
+
+func (utf8Charset) Decode(src []byte) ([]byte, error) {
+	if !utf8.Valid(src) {
+		return nil, fmt.Errorf("gofido: decoding UTF-8: invalid byte sequence")
+	}
+	return src, nil
+}
+
+func (utf8Charset) Encode(src []byte) ([]byte, error) {
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRune(src[i:])
+		if r == utf8.RuneError && size == 1 {
+			return nil, &CharsetError{Charset: "UTF-8", Rune: r, Offset: i}
+		}
+		i += size
+	}
+	return src, nil
+}
@@ -0,0 +1,50 @@
+package gofido
+
+import "testing"
+
+func TestCharsetEncodeDecodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"CP866", "Привет, мир!"},
+		{"CP437", "cafe \x04 noel"},
+		{"LATIN-1", "café"},
+		{"KOI8-R", "Привет, мир!"},
+		{"UTF-8", "hello éè"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs, ok := LookupCharset(tt.name)
+			if !ok {
+				t.Fatalf("LookupCharset(%q) not found", tt.name)
+			}
+			encoded, err := cs.Encode([]byte(tt.text))
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			decoded, err := cs.Decode(encoded)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if string(decoded) != tt.text {
+				t.Errorf("round trip = %q, want %q", decoded, tt.text)
+			}
+		})
+	}
+}
+
+func TestCharsetEncodeUnrepresentableRune(t *testing.T) {
+	cs, _ := LookupCharset("CP437")
+	_, err := cs.Encode([]byte("price: €")) // EURO SIGN isn't in CP437
+	if err == nil {
+		t.Fatal("Encode succeeded for a rune CP437 can't represent")
+	}
+	cerr, ok := err.(*CharsetError)
+	if !ok {
+		t.Fatalf("error = %v, want *CharsetError", err)
+	}
+	if cerr.Rune != '€' {
+		t.Errorf("CharsetError.Rune = %q, want €", cerr.Rune)
+	}
+}
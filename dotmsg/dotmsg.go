@@ -0,0 +1,265 @@
+// Package dotmsg implements the classic one-message-per-file *.msg message
+// base (as used by Opus/SEAdog-style mailers) as a gofido.MessageBase. Each
+// message is stored as "<num>.msg" inside a directory, with a fixed binary
+// header followed directly by the message text.
+package dotmsg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/undead-ru/gofido"
+)
+
+// Header is the classic fixed-size *.msg header. The message text follows
+// it directly and runs to the end of the file.
+type Header struct {
+	From      [36]byte
+	To        [36]byte
+	Subj      [72]byte
+	DateTime  [20]byte
+	TimesRead uint16
+	DestNode  uint16
+	OrigNode  uint16
+	Cost      uint16
+	OrigNet   uint16
+	DestNet   uint16
+	DestZone  uint16
+	OrigZone  uint16
+	DestPoint uint16
+	OrigPoint uint16
+	ReplyTo   uint32
+	Attribute uint16
+	NextReply uint32
+}
+
+// Base is a *.msg directory backend, implementing gofido.MessageBase.
+type Base struct {
+	dir string
+}
+
+// New returns an unopened *.msg Base.
+func New() *Base {
+	return &Base{}
+}
+
+// Open points the base at directory path, creating it if createNew is true.
+func (b *Base) Open(path string, createNew bool) error {
+	if _, err := os.Stat(path); err != nil {
+		if !os.IsNotExist(err) || !createNew {
+			return fmt.Errorf("dotmsg: opening %s: %w", path, err)
+		}
+		if err = os.MkdirAll(path, 0755); err != nil {
+			return fmt.Errorf("dotmsg: creating %s: %w", path, err)
+		}
+	}
+	b.dir = path
+	return nil
+}
+
+// Close is a no-op: every file is opened and closed per operation.
+func (b *Base) Close() error {
+	return nil
+}
+
+func (b *Base) msgNums() ([]uint32, error) {
+	entries, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return nil, fmt.Errorf("dotmsg: reading %s: %w", b.dir, err)
+	}
+	var nums []uint32
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(strings.ToLower(name), ".msg") {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSuffix(name, filepath.Ext(name)), 10, 32)
+		if err != nil {
+			continue
+		}
+		nums = append(nums, uint32(n))
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums, nil
+}
+
+// NumMessages returns how many "<num>.msg" files the directory holds.
+func (b *Base) NumMessages() (uint32, error) {
+	nums, err := b.msgNums()
+	return uint32(len(nums)), err
+}
+
+// Append writes msg to the next sequential "<num>.msg" file and returns its
+// message number.
+func (b *Base) Append(msg gofido.FidoMessage) (num uint32, err error) {
+	nums, err := b.msgNums()
+	if err != nil {
+		return 0, err
+	}
+	num = 1
+	if len(nums) > 0 {
+		num = nums[len(nums)-1] + 1
+	}
+
+	hdr := Header{
+		DestNode:  msg.ToAddr.Node,
+		OrigNode:  msg.FromAddr.Node,
+		OrigNet:   msg.FromAddr.Network,
+		DestNet:   msg.ToAddr.Network,
+		DestZone:  msg.ToAddr.Zone,
+		OrigZone:  msg.FromAddr.Zone,
+		DestPoint: msg.ToAddr.Point,
+		OrigPoint: msg.FromAddr.Point,
+		Attribute: msg.Attributes,
+	}
+	copy(hdr.From[:], msg.FromName)
+	copy(hdr.To[:], msg.ToName)
+	copy(hdr.Subj[:], msg.Subj)
+	copy(hdr.DateTime[:], msg.DateTime.Format(gofido.PktDateTimeLayout))
+
+	file, err := os.Create(filepath.Join(b.dir, fmt.Sprintf("%d.msg", num)))
+	if err != nil {
+		return 0, fmt.Errorf("dotmsg: creating message %d: %w", num, err)
+	}
+	defer file.Close()
+
+	if err = binary.Write(file, binary.LittleEndian, hdr); err != nil {
+		return 0, fmt.Errorf("dotmsg: writing message %d header: %w", num, err)
+	}
+	text := strings.Replace(msg.Text, "\n", "\r", -1)
+	if _, err = file.WriteString(text); err != nil {
+		return 0, fmt.Errorf("dotmsg: writing message %d text: %w", num, err)
+	}
+	return num, nil
+}
+
+// ReadByNum returns the message stored in "<num>.msg".
+func (b *Base) ReadByNum(num uint32) (gofido.FidoMessage, error) {
+	var msg gofido.FidoMessage
+	file, err := os.Open(filepath.Join(b.dir, fmt.Sprintf("%d.msg", num)))
+	if err != nil {
+		return msg, fmt.Errorf("dotmsg: opening message %d: %w", num, err)
+	}
+	defer file.Close()
+
+	var hdr Header
+	if err = binary.Read(file, binary.LittleEndian, &hdr); err != nil {
+		return msg, fmt.Errorf("dotmsg: reading message %d header: %w", num, err)
+	}
+	body, err := io.ReadAll(file)
+	if err != nil {
+		return msg, fmt.Errorf("dotmsg: reading message %d text: %w", num, err)
+	}
+
+	msg.FromName = nullTermString(hdr.From[:])
+	msg.ToName = nullTermString(hdr.To[:])
+	msg.Subj = nullTermString(hdr.Subj[:])
+	msg.Text = strings.Replace(string(body), "\r", "\n", -1)
+	msg.Attributes = hdr.Attribute
+	msg.FromAddr = gofido.FidoNetAddress{Zone: hdr.OrigZone, Network: hdr.OrigNet, Node: hdr.OrigNode, Point: hdr.OrigPoint}
+	msg.ToAddr = gofido.FidoNetAddress{Zone: hdr.DestZone, Network: hdr.DestNet, Node: hdr.DestNode, Point: hdr.DestPoint}
+	return msg, nil
+}
+
+func nullTermString(b []byte) string {
+	if i := indexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func indexByte(b []byte, c byte) int {
+	for i, v := range b {
+		if v == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// Lock takes an exclusive lock on the base using the classic FTN ".bsy"
+// semaphore file convention.
+func (b *Base) Lock() error {
+	f, err := os.OpenFile(filepath.Join(b.dir, "msgbase.bsy"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("dotmsg: locking %s: %w", b.dir, err)
+	}
+	return f.Close()
+}
+
+// Unlock removes the lock taken by Lock.
+func (b *Base) Unlock() error {
+	if err := os.Remove(filepath.Join(b.dir, "msgbase.bsy")); err != nil {
+		return fmt.Errorf("dotmsg: unlocking %s: %w", b.dir, err)
+	}
+	return nil
+}
+
+type lastReadRecord struct {
+	UserCRC     uint32
+	LastRead    uint32
+	HighestRead uint32
+}
+
+func (b *Base) lastReadPath() string {
+	return filepath.Join(b.dir, "lastread.dat")
+}
+
+func (b *Base) findLastRead(userName string) (lastReadRecord, int64, bool, error) {
+	crc := crc32.ChecksumIEEE([]byte(strings.ToLower(userName)))
+	file, err := os.OpenFile(b.lastReadPath(), os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return lastReadRecord{}, 0, false, fmt.Errorf("dotmsg: opening last-read file: %w", err)
+	}
+	defer file.Close()
+
+	var offset int64
+	for {
+		var rec lastReadRecord
+		if err := binary.Read(file, binary.LittleEndian, &rec); err != nil {
+			return lastReadRecord{UserCRC: crc}, offset, false, nil
+		}
+		if rec.UserCRC == crc {
+			return rec, offset, true, nil
+		}
+		offset += 12
+	}
+}
+
+// LastRead returns the last-read message number recorded for userName, or 0
+// if the base has no record for that user.
+func (b *Base) LastRead(userName string) (uint32, error) {
+	rec, _, _, err := b.findLastRead(userName)
+	return rec.LastRead, err
+}
+
+// SetLastRead records num as the last message userName has read.
+func (b *Base) SetLastRead(userName string, num uint32) error {
+	rec, offset, _, err := b.findLastRead(userName)
+	if err != nil {
+		return err
+	}
+	rec.LastRead = num
+	if num > rec.HighestRead {
+		rec.HighestRead = num
+	}
+
+	file, err := os.OpenFile(b.lastReadPath(), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("dotmsg: opening last-read file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("dotmsg: seeking last-read file: %w", err)
+	}
+	return binary.Write(file, binary.LittleEndian, rec)
+}
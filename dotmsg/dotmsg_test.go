@@ -0,0 +1,46 @@
+package dotmsg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/undead-ru/gofido"
+)
+
+func TestAppendReadByNumRoundTrip(t *testing.T) {
+	base := New()
+	if err := base.Open(t.TempDir(), true); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer base.Close()
+
+	msg := gofido.FidoMessage{
+		FromName: "Alice",
+		ToName:   "Bob",
+		FromAddr: gofido.FidoNetAddress{Zone: 2, Network: 1, Node: 1},
+		ToAddr:   gofido.FidoNetAddress{Zone: 2, Network: 1, Node: 2},
+		Subj:     "hi",
+		Text:     "\x01MSGID: 2:1/1 12345678\nhello there\n",
+		DateTime: time.Date(2026, time.July, 27, 12, 0, 0, 0, time.UTC),
+	}
+
+	num, err := base.Append(msg)
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	got, err := base.ReadByNum(num)
+	if err != nil {
+		t.Fatalf("ReadByNum: %v", err)
+	}
+
+	if got.FromName != msg.FromName || got.ToName != msg.ToName || got.Subj != msg.Subj {
+		t.Errorf("fields = %+v, want matching %+v", got, msg)
+	}
+	if got.FromAddr != msg.FromAddr || got.ToAddr != msg.ToAddr {
+		t.Errorf("addresses = from %+v, to %+v, want from %+v, to %+v", got.FromAddr, got.ToAddr, msg.FromAddr, msg.ToAddr)
+	}
+	if got.Text != msg.Text {
+		t.Errorf("Text = %q, want %q", got.Text, msg.Text)
+	}
+}
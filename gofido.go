@@ -1,17 +1,16 @@
 package gofido
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"golang.org/x/text/encoding/charmap"
-	"log"
+	"io"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
-	"unsafe"
 )
 
 // FidoNetAddress structure of FidoNet address zone:network/node.point@domain
@@ -54,7 +53,71 @@ type PktHeader struct {
 	Password   [8]byte // session password  (otherwise null)
 	OrigZone   uint16  // zone of pkt sender (otherwise null)
 	DestZone   uint16  // zone of pkt receiver (otherwise null)
-	Filled     [20]byte
+
+	// The fields below replace the 20 bytes historically labelled "Filled".
+	// FTS-0001 leaves them zero; FSC-0039 uses AuxNet to carry the
+	// originating system's point, and FSC-0048 (Type 2+) uses CapValid/CapWord to advertise
+	// capabilities and OrigPoint/DestPoint to carry packed point addresses.
+	// See PacketVariant and detectVariant.
+	AuxNet    uint16 // FSC-0039: point of the packet's OrigAddr
+	CapValid  uint16 // FSC-0048: must equal CapValidWord for CapWord to apply
+	ProdHigh  byte   // high byte of a 16-bit ProdCode
+	CapWord   uint16 // FSC-0048 capability word, see Cap* flags
+	OrigPoint uint16 // FSC-0048: point of the packet's OrigAddr
+	DestPoint uint16 // FSC-0048: point of the packet's DestAddr
+	Reserved  [9]byte
+}
+
+// PacketVariant identifies which packet header flavour PktWrite should emit
+// and PktRead/PktScanner detected on the wire.
+type PacketVariant int
+
+const (
+	// VariantType2 is the plain FTS-0001 Type 2 packet: no point addressing.
+	VariantType2 PacketVariant = iota
+	// VariantType22 is the FSC-0039 Type 2.2 packet: a point address for the
+	// originating system is carried in AuxNet.
+	VariantType22
+	// VariantType2Plus is the FSC-0048 Type 2+ packet: CapValid/CapWord are
+	// set and OrigPoint/DestPoint carry both ends' point addresses.
+	VariantType2Plus
+)
+
+func (v PacketVariant) String() string {
+	switch v {
+	case VariantType2:
+		return "Type 2"
+	case VariantType22:
+		return "Type 2.2 (FSC-0039)"
+	case VariantType2Plus:
+		return "Type 2+ (FSC-0048)"
+	default:
+		return fmt.Sprintf("PacketVariant(%d)", int(v))
+	}
+}
+
+const (
+	// CapValidWord is the value CapValid must hold for CapWord to be honoured.
+	CapValidWord uint16 = 0x0001
+	// CapPackedPoint marks that OrigPoint/DestPoint carry point addresses.
+	CapPackedPoint uint16 = 0x0001
+)
+
+// detectVariant inspects a packet header's capability region and reports
+// which PacketVariant produced it. PacketType and ProdCode/ProdHigh aren't
+// part of that detection: PacketType is 2 for every one of these variants
+// (FSC-0039 and FSC-0048 both extend Type 2, they don't replace it), and
+// ProdCode/ProdHigh identify the originating software, not the packet
+// flavour. CapValid/CapWord and AuxNet are what the header actually uses to
+// signal Type 2+ and Type 2.2.
+func detectVariant(h PktHeader) PacketVariant {
+	if h.CapValid == CapValidWord && h.CapWord&CapPackedPoint != 0 {
+		return VariantType2Plus
+	}
+	if h.AuxNet != 0 {
+		return VariantType22
+	}
+	return VariantType2
 }
 
 type pktMsgHeader struct {
@@ -78,6 +141,12 @@ const (
 	PktDateTimeLayout = "02 Jan 06  15:04:05"
 )
 
+// pktMsgMarker precedes every message in a .pkt file
+var pktMsgMarker = [2]byte{0x02, 0x00}
+
+// pktPacketTerminator is the trailing zero word that ends a .pkt file
+var pktPacketTerminator = [2]byte{0x00, 0x00}
+
 // ReFidoNetAddress regular expression matches standard FidoNet address string
 var ReFidoNetAddress = regexp.MustCompile(`^(\d{1,4}):(\d{1,5})/(\d{1,5})[.]?(\d{1,5})?[@]?([a-z]*)$`)
 
@@ -142,64 +211,378 @@ func GetOutboundDir(fAddr FidoNetAddress) string {
 	return strings.ToLower(fmt.Sprintf("%04X%04X.pnt", fAddr.Network, fAddr.Node))
 }
 
-// PktWrite creates and write .pkt file
-func PktWrite(pktFileName string, pktHead PktHeader, pktPassword string, messages []FidoMessage) {
+// PktScanner reads a .pkt stream message by message, so a caller can process
+// large mail bundles without holding every FidoMessage in memory at once.
+// Use NewPktScanner to create one, then call Scan in a loop the same way
+// bufio.Scanner is used.
+type PktScanner struct {
+	r              *bufio.Reader
+	header         PktHeader
+	variant        PacketVariant
+	password       string
+	defaultCharset Charset
+	msg            FidoMessage
+	err            error
+	done           bool
+}
+
+// NewPktScanner reads the packet header from r and returns a PktScanner
+// ready to walk the messages that follow. Each message's charset is picked
+// from its CHRS: kludge, if any, falling back to DefaultCharset; use
+// SetDefaultCharset to override the fallback.
+func NewPktScanner(r io.Reader) (*PktScanner, error) {
+	br := bufio.NewReader(r)
+	var header PktHeader
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("gofido: reading packet header: %w", err)
+	}
+	return &PktScanner{
+		r:              br,
+		header:         header,
+		variant:        detectVariant(header),
+		password:       strings.TrimRight(string(header.Password[:]), "\x00"),
+		defaultCharset: DefaultCharset,
+	}, nil
+}
 
+// SetDefaultCharset overrides the Charset Scan falls back to for messages
+// that carry no CHRS: kludge.
+func (s *PktScanner) SetDefaultCharset(cs Charset) {
+	s.defaultCharset = cs
 }
 
-// PktRead returns slice of Messages from .pkt file
-func PktRead(pktFileName string) (pktHead PktHeader, pktPassword string, messages []FidoMessage, err error) {
-	file, err := os.Open(pktFileName)
+// Header returns the .pkt header read by NewPktScanner.
+func (s *PktScanner) Header() PktHeader {
+	return s.header
+}
+
+// Variant reports the PacketVariant detected from the packet header.
+func (s *PktScanner) Variant() PacketVariant {
+	return s.variant
+}
+
+// Password returns the session password carried in the packet header.
+func (s *PktScanner) Password() string {
+	return s.password
+}
+
+// Scan advances to the next message in the packet, returning false once the
+// packet terminator is reached or an error occurs. Callers should check Err
+// after Scan returns false.
+func (s *PktScanner) Scan() bool {
+	if s.done {
+		return false
+	}
+
+	var marker [2]byte
+	if _, err := io.ReadFull(s.r, marker[:]); err != nil {
+		if err != io.EOF {
+			s.err = fmt.Errorf("gofido: reading message marker: %w", err)
+		}
+		s.done = true
+		return false
+	}
+	if marker == pktPacketTerminator {
+		s.done = true
+		return false
+	}
+	if marker != pktMsgMarker {
+		s.err = fmt.Errorf("gofido: unexpected message marker %#v", marker)
+		s.done = true
+		return false
+	}
+
+	var pktMsg pktMsgHeader
+	if err := binary.Read(s.r, binary.LittleEndian, &pktMsg); err != nil {
+		s.err = fmt.Errorf("gofido: reading message header: %w", err)
+		s.done = true
+		return false
+	}
+
+	var msg FidoMessage
+	var err error
+	var rawTo, rawFrom, rawSubj, rawText []byte
+	if rawTo, err = readFieldRawUntilZero(s.r, PktUserNameSize); err != nil {
+		s.err = err
+		s.done = true
+		return false
+	}
+	if rawFrom, err = readFieldRawUntilZero(s.r, PktUserNameSize); err != nil {
+		s.err = err
+		s.done = true
+		return false
+	}
+	if rawSubj, err = readFieldRawUntilZero(s.r, PktSubjectSize); err != nil {
+		s.err = err
+		s.done = true
+		return false
+	}
+	if rawText, err = readFieldRawUntilZero(s.r, PktTextSize); err != nil {
+		s.err = err
+		s.done = true
+		return false
+	}
+
+	cs := charsetFromCHRSKludge(rawText, s.defaultCharset)
+	if msg.ToName, err = decodeField(cs, rawTo); err != nil {
+		s.err = err
+		s.done = true
+		return false
+	}
+	if msg.FromName, err = decodeField(cs, rawFrom); err != nil {
+		s.err = err
+		s.done = true
+		return false
+	}
+	if msg.Subj, err = decodeField(cs, rawSubj); err != nil {
+		s.err = err
+		s.done = true
+		return false
+	}
+	if msg.Text, err = decodeField(cs, rawText); err != nil {
+		s.err = err
+		s.done = true
+		return false
+	}
+	if msg.DateTime, err = time.Parse(PktDateTimeLayout, string(pktMsg.DateTime[:19])); err != nil {
+		s.err = fmt.Errorf("gofido: parsing message date: %w", err)
+		s.done = true
+		return false
+	}
+	msg.Attributes = pktMsg.AttributeWord
+	msg.FromAddr = FidoNetAddress{
+		Zone:    s.header.OrigZone,
+		Network: pktMsg.OrigNet,
+		Node:    pktMsg.OrigNode,
+	}
+	msg.ToAddr = FidoNetAddress{
+		Zone:    s.header.DestZone,
+		Network: pktMsg.DestNet,
+		Node:    pktMsg.DestNode,
+	}
+	switch s.variant {
+	case VariantType2Plus:
+		msg.FromAddr.Point = s.header.OrigPoint
+		msg.ToAddr.Point = s.header.DestPoint
+	case VariantType22:
+		// FSC-0039 only carries a point address for the originating system.
+		msg.FromAddr.Point = s.header.AuxNet
+	}
+
+	s.msg = msg
+	return true
+}
+
+// Message returns the message produced by the most recent call to Scan.
+func (s *PktScanner) Message() FidoMessage {
+	return s.msg
+}
+
+// Err returns the first non-EOF error encountered by Scan.
+func (s *PktScanner) Err() error {
+	return s.err
+}
+
+// readFieldRawUntilZero reads a null-terminated field of at most max bytes
+// (including the terminator), translating the .pkt's CR line endings to LF.
+// The bytes are left in their original charset encoding; the caller decodes
+// once the message's charset is known.
+func readFieldRawUntilZero(r *bufio.Reader, max int) ([]byte, error) {
+	raw := make([]byte, 0, max)
+	for i := 0; i < max; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("gofido: reading field: %w", err)
+		}
+		if b == 0x00 {
+			return raw, nil
+		}
+		if b == 0x0D {
+			b = 0x0A
+		}
+		raw = append(raw, b)
+	}
+	return raw, nil
+}
+
+// charsetFromCHRSKludge inspects raw (a message's undecoded Text field) for
+// a CHRS: kludge and returns the Charset it names, falling back to def if
+// there's no CHRS: kludge or it names an unregistered charset. This relies
+// on kludge lines being plain ASCII, which reads identically regardless of
+// which charset the rest of the message turns out to be in.
+func charsetFromCHRSKludge(raw []byte, def Charset) Charset {
+	kludges := GetKludges(string(raw))
+	chrs, ok := kludges["CHRS"]
+	if !ok {
+		return def
+	}
+	fields := strings.Fields(chrs)
+	if len(fields) == 0 {
+		return def
+	}
+	if cs, ok := LookupCharset(fields[0]); ok {
+		return cs
+	}
+	return def
+}
+
+// decodeField decodes raw from cs into a UTF-8 string.
+func decodeField(cs Charset, raw []byte) (string, error) {
+	decoded, err := cs.Decode(raw)
+	if err != nil {
+		return "", fmt.Errorf("gofido: decoding field: %w", err)
+	}
+	return string(decoded), nil
+}
+
+// PktRead reads a whole .pkt stream from r and returns every message it
+// contains. Large bundles that shouldn't be loaded into memory at once
+// should use NewPktScanner directly instead.
+func PktRead(r io.Reader) (pktHead PktHeader, pktPassword string, messages []FidoMessage, err error) {
+	scanner, err := NewPktScanner(r)
 	if err != nil {
 		return
 	}
-	defer file.Close()
+	pktHead = scanner.Header()
+	pktPassword = scanner.Password()
+	for scanner.Scan() {
+		messages = append(messages, scanner.Message())
+	}
+	err = scanner.Err()
+	return
+}
 
-	data := readNextBytes(file, int(unsafe.Sizeof(PktHeader{})))
-	buffer := bytes.NewBuffer(data)
-	err = binary.Read(buffer, binary.LittleEndian, &pktHead)
+// PktReadFile opens pktFileName and reads it with PktRead.
+func PktReadFile(pktFileName string) (pktHead PktHeader, pktPassword string, messages []FidoMessage, err error) {
+	file, err := os.Open(pktFileName)
 	if err != nil {
 		return
 	}
+	defer file.Close()
+	return PktRead(file)
+}
+
+// PktWrite writes pktHead, pktPassword and messages to w as a .pkt stream in
+// the given PacketVariant, encoding message text fields with charset and
+// tagging each message with a matching CHRS: kludge. pktHead.AuxNet
+// (Type 2.2) or pktHead.OrigPoint/DestPoint (Type 2+) must already carry the
+// point addresses. For Type 2+ PktWrite sets the capability bits that mark
+// them valid, for Type 2.2 it clears CapValid/CapWord, and for plain Type 2
+// it zeroes AuxNet, CapValid/CapWord and OrigPoint/DestPoint. If a message contains a rune charset can't represent, PktWrite
+// returns a *CharsetError rather than panicking.
+func PktWrite(w io.Writer, pktHead PktHeader, pktPassword string, messages []FidoMessage, variant PacketVariant, charset Charset) error {
+	copy(pktHead.Password[:], pktPassword)
+
+	switch variant {
+	case VariantType2Plus:
+		pktHead.CapValid = CapValidWord
+		pktHead.CapWord |= CapPackedPoint
+	case VariantType22:
+		pktHead.CapValid = 0
+		pktHead.CapWord = 0
+	case VariantType2:
+		pktHead.AuxNet = 0
+		pktHead.CapValid = 0
+		pktHead.CapWord = 0
+		pktHead.OrigPoint = 0
+		pktHead.DestPoint = 0
+	}
 
-	pktPassword = string(pktHead.Password[:8])
+	if err := binary.Write(w, binary.LittleEndian, pktHead); err != nil {
+		return fmt.Errorf("gofido: writing packet header: %w", err)
+	}
 
-	for {
-		data = readNextBytes(file, 2)
-		if data[0] != 0x02 || data[1] != 0x00 {
-			return
+	for _, msg := range messages {
+		if _, err := w.Write(pktMsgMarker[:]); err != nil {
+			return fmt.Errorf("gofido: writing message marker: %w", err)
 		}
-		var pktMsg pktMsgHeader
-		var msgInstance FidoMessage
-		data = readNextBytes(file, int(unsafe.Sizeof(pktMsgHeader{})))
-		buffer = bytes.NewBuffer(data)
-		err = binary.Read(buffer, binary.LittleEndian, &pktMsg)
-		if err != nil {
-			return
+
+		pktMsg := pktMsgHeader{
+			OrigNode:      msg.FromAddr.Node,
+			DestNode:      msg.ToAddr.Node,
+			OrigNet:       msg.FromAddr.Network,
+			DestNet:       msg.ToAddr.Network,
+			AttributeWord: msg.Attributes,
 		}
-		msgInstance.ToName = string(CP866toUTF8(readNextBytesUntilZero(file, PktUserNameSize)))
-		msgInstance.FromName = string(CP866toUTF8(readNextBytesUntilZero(file, PktUserNameSize)))
-		msgInstance.Subj = string(CP866toUTF8(readNextBytesUntilZero(file, PktSubjectSize)))
-		msgInstance.Text = string(CP866toUTF8(readNextBytesUntilZero(file, PktTextSize)))
-		msgInstance.DateTime, err = time.Parse(PktDateTimeLayout, string(pktMsg.DateTime[:19]))
-		if err != nil {
-			return
+		copy(pktMsg.DateTime[:], msg.DateTime.Format(PktDateTimeLayout))
+		if err := binary.Write(w, binary.LittleEndian, pktMsg); err != nil {
+			return fmt.Errorf("gofido: writing message header: %w", err)
+		}
+
+		if err := writeCharsetField(w, msg.ToName, PktUserNameSize, charset); err != nil {
+			return err
+		}
+		if err := writeCharsetField(w, msg.FromName, PktUserNameSize, charset); err != nil {
+			return err
+		}
+		if err := writeCharsetField(w, msg.Subj, PktSubjectSize, charset); err != nil {
+			return err
+		}
+		if err := writeCharsetField(w, withCHRSKludge(msg.Text, charset), PktTextSize, charset); err != nil {
+			return err
 		}
-		msgInstance.FromAddr = FidoNetAddress{
-			uint16(pktHead.OrigZone),
-			uint16(pktMsg.OrigNet),
-			uint16(pktMsg.OrigNode),
-			0,
-			""}
-		msgInstance.ToAddr = FidoNetAddress{
-			uint16(pktHead.DestZone),
-			uint16(pktMsg.DestNet),
-			uint16(pktMsg.DestNode),
-			0,
-			""}
-		messages = append(messages, msgInstance)
 	}
-	return
+
+	if _, err := w.Write(pktPacketTerminator[:]); err != nil {
+		return fmt.Errorf("gofido: writing packet terminator: %w", err)
+	}
+	return nil
+}
+
+// PktWriteFile creates pktFileName and writes it with PktWrite.
+func PktWriteFile(pktFileName string, pktHead PktHeader, pktPassword string, messages []FidoMessage, variant PacketVariant, charset Charset) error {
+	file, err := os.Create(pktFileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return PktWrite(file, pktHead, pktPassword, messages, variant, charset)
+}
+
+// withCHRSKludge returns text with any existing CHRS: kludge line stripped
+// and a fresh one naming cs inserted, so a round-tripped message doesn't
+// accumulate duplicate CHRS: lines and readers always see the charset that
+// was actually used to encode it. The CHRS line goes after a leading AREA:
+// line, if any - AREA: must stay line 0 per FTS-0001, and GetKludges,
+// KludgeLines and GetMsgBody all rely on that to recognize it.
+func withCHRSKludge(text string, cs Charset) string {
+	lines := strings.Split(text, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.HasPrefix(line, "\x01CHRS:") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	chrsLine := fmt.Sprintf("\x01CHRS: %s %d", cs.Name(), cs.Level())
+	if len(kept) > 0 && strings.HasPrefix(kept[0], "AREA:") {
+		withChrs := append([]string{kept[0], chrsLine}, kept[1:]...)
+		return strings.Join(withChrs, "\n")
+	}
+	return chrsLine + "\n" + strings.Join(kept, "\n")
+}
+
+// writeCharsetField encodes s with cs, translates LF back to the .pkt CR
+// line ending, truncates it to fit within max bytes including the
+// terminator, and writes it to w null-terminated. If s contains a rune cs
+// can't represent, it returns the *CharsetError from cs.Encode rather than
+// panicking.
+func writeCharsetField(w io.Writer, s string, max int, cs Charset) error {
+	encoded, err := cs.Encode([]byte(s))
+	if err != nil {
+		return err
+	}
+	encoded = bytes.Replace(encoded, []byte{0x0A}, []byte{0x0D}, -1)
+	if len(encoded) > max-1 {
+		encoded = encoded[:max-1]
+	}
+	if _, err := w.Write(encoded); err != nil {
+		return fmt.Errorf("gofido: writing field: %w", err)
+	}
+	if _, err := w.Write([]byte{0x00}); err != nil {
+		return fmt.Errorf("gofido: writing field terminator: %w", err)
+	}
+	return nil
 }
 
 // GetKludges returns a map of kludges (without colons) from message text (body)
@@ -227,6 +610,29 @@ func GetKludges(msgText string) map[string]string {
 	return kludges
 }
 
+// KludgeLines returns the raw kludge-bearing lines of msgText - the leading
+// AREA: line (if present) and every SEEN-BY: or control-A prefixed kludge
+// line - in their original order and exactly as they appear in msgText.
+// This is GetMsgBody's complement: together, KludgeLines and GetMsgBody
+// account for every line of msgText, so a MessageBase backend that can't
+// otherwise preserve a kludge verbatim can store these lines as opaque
+// blobs and replay them ahead of the body. That reproduces msgText only
+// when every kludge line precedes the body text; kludge lines that follow
+// it (such as trailing SEEN-BY: and PATH lines) end up ahead of it.
+func KludgeLines(msgText string) []string {
+	var lines []string
+	for i, str := range strings.Split(msgText, "\n") {
+		if i == 0 && strings.HasPrefix(str, "AREA:") {
+			lines = append(lines, str)
+			continue
+		}
+		if strings.HasPrefix(str, "SEEN-BY:") || strings.HasPrefix(str, string([]byte{0x01})) {
+			lines = append(lines, str)
+		}
+	}
+	return lines
+}
+
 // GetMsgBody returns message body without kludges
 func GetMsgBody(msgText string) (text string) {
 	for i, str := range strings.Split(msgText, "\n") {
@@ -241,55 +647,3 @@ func GetMsgBody(msgText string) (text string) {
 	}
 	return
 }
-
-func readNextBytes(file *os.File, len int) []byte {
-	bytesGet := make([]byte, len)
-	_, err := file.Read(bytesGet)
-	if err != nil {
-		log.Fatalf("ERROR: %v", err)
-	}
-	return bytesGet
-}
-
-func readNextBytesUntilZero(file *os.File, len int) []byte {
-	bytesGet := make([]byte, 1)
-	bytesRet := make([]byte, 0)
-	for i := 0; i <= len; i++ {
-		_, err := file.Read(bytesGet)
-		if err != nil {
-			log.Fatalf("ERROR: %v", err)
-		}
-		if bytesGet[0] == 0x00 {
-			return bytesRet
-		}
-		if bytesGet[0] == 0x0D {
-			bytesGet[0] = 0x0A
-		}
-		bytesRet = append(bytesRet, bytesGet[0])
-	}
-	return bytesRet
-}
-
-// CP866toUTF8 converts slice of bytes from CP866 codepage to UTF8
-func CP866toUTF8(src []byte) []byte {
-	dec := charmap.CodePage866.NewDecoder()
-	newBody := make([]byte, len(src)*2)
-	n, _, err := dec.Transform(newBody, src, false)
-	if err != nil {
-		panic(err)
-	}
-	newBody = newBody[:n]
-	return newBody
-}
-
-// UTF8toCP866 converts slice of bytes from UTF8 to CP866 codepage (changing russian "Ð" char to similar latin "H")
-func UTF8toCP866(src []byte) []byte {
-	dec := charmap.CodePage866.NewEncoder()
-	newBody := make([]byte, len(src))
-	n, _, err := dec.Transform(newBody, src, false)
-	if err != nil {
-		panic(err)
-	}
-	newBody = bytes.Replace(newBody[:n], []byte{0x8D}, []byte{0x48}, -1)
-	return newBody
-}
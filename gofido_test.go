@@ -0,0 +1,156 @@
+package gofido
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPktWriteReadRoundTrip(t *testing.T) {
+	head := PktHeader{
+		OrigNode: 1, DestNode: 2,
+		OrigNet: 10, DestNet: 20,
+		OrigZone: 2, DestZone: 2,
+	}
+	msgs := []FidoMessage{
+		{
+			FromName: "Alice", ToName: "Bob", Subj: "hi",
+			Text:     "hello there\n",
+			DateTime: time.Date(2026, time.July, 27, 12, 0, 0, 0, time.UTC),
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := PktWrite(&buf, head, "secret", msgs, VariantType2, DefaultCharset); err != nil {
+		t.Fatalf("PktWrite: %v", err)
+	}
+
+	gotHead, gotPassword, gotMsgs, err := PktRead(&buf)
+	if err != nil {
+		t.Fatalf("PktRead: %v", err)
+	}
+	if gotPassword != "secret" {
+		t.Errorf("password = %q, want %q", gotPassword, "secret")
+	}
+	if gotHead.OrigNode != head.OrigNode || gotHead.DestNode != head.DestNode {
+		t.Errorf("header node addressing = %+v, want orig/dest matching %+v", gotHead, head)
+	}
+	if len(gotMsgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(gotMsgs))
+	}
+	got := gotMsgs[0]
+	if got.FromName != "Alice" || got.ToName != "Bob" || got.Subj != "hi" {
+		t.Errorf("message fields = %+v", got)
+	}
+	if GetMsgBody(got.Text) != "hello there\n\n" {
+		t.Errorf("message body = %q, want %q", GetMsgBody(got.Text), "hello there\n\n")
+	}
+}
+
+func TestPktWriteReadType2PlusPackedPoints(t *testing.T) {
+	head := PktHeader{
+		OrigNode: 1, DestNode: 2,
+		OrigNet: 10, DestNet: 20,
+		OrigZone: 2, DestZone: 2,
+		OrigPoint: 5, DestPoint: 7,
+	}
+	msgs := []FidoMessage{
+		{
+			FromName: "Alice", ToName: "Bob", Subj: "hi",
+			Text:     "hello there\n",
+			DateTime: time.Date(2026, time.July, 27, 12, 0, 0, 0, time.UTC),
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := PktWrite(&buf, head, "", msgs, VariantType2Plus, DefaultCharset); err != nil {
+		t.Fatalf("PktWrite: %v", err)
+	}
+
+	gotHead, _, gotMsgs, err := PktRead(&buf)
+	if err != nil {
+		t.Fatalf("PktRead: %v", err)
+	}
+	if gotHead.CapValid != CapValidWord || gotHead.CapWord&CapPackedPoint == 0 {
+		t.Errorf("header capability bits not set: %+v", gotHead)
+	}
+	if len(gotMsgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(gotMsgs))
+	}
+	if gotMsgs[0].FromAddr.Point != 5 || gotMsgs[0].ToAddr.Point != 7 {
+		t.Errorf("message point addressing = from %d, to %d, want 5, 7", gotMsgs[0].FromAddr.Point, gotMsgs[0].ToAddr.Point)
+	}
+}
+
+func TestPktWriteReadType22PointAddress(t *testing.T) {
+	head := PktHeader{
+		OrigNode: 1, DestNode: 2,
+		OrigNet: 10, DestNet: 20,
+		OrigZone: 2, DestZone: 2,
+		AuxNet: 5,
+	}
+	msgs := []FidoMessage{
+		{
+			FromName: "Alice", ToName: "Bob", Subj: "hi",
+			Text:     "hello there\n",
+			DateTime: time.Date(2026, time.July, 27, 12, 0, 0, 0, time.UTC),
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := PktWrite(&buf, head, "", msgs, VariantType22, DefaultCharset); err != nil {
+		t.Fatalf("PktWrite: %v", err)
+	}
+
+	gotHead, _, gotMsgs, err := PktRead(&buf)
+	if err != nil {
+		t.Fatalf("PktRead: %v", err)
+	}
+	if gotHead.AuxNet != 5 {
+		t.Errorf("header AuxNet = %d, want 5", gotHead.AuxNet)
+	}
+	if len(gotMsgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(gotMsgs))
+	}
+	if gotMsgs[0].FromAddr.Point != 5 {
+		t.Errorf("FromAddr.Point = %d, want 5", gotMsgs[0].FromAddr.Point)
+	}
+	if gotMsgs[0].ToAddr.Point != 0 {
+		t.Errorf("ToAddr.Point = %d, want 0 (FSC-0039 carries only the origin's point)", gotMsgs[0].ToAddr.Point)
+	}
+}
+
+// TestPktWriteReadPreservesAREALine checks that PktWrite's CHRS: kludge
+// doesn't bump a leading AREA: line out of line 0 - FTS-0001 requires
+// AREA: to be the first line of echomail text, and GetKludges/KludgeLines/
+// GetMsgBody all rely on that to recognize it.
+func TestPktWriteReadPreservesAREALine(t *testing.T) {
+	head := PktHeader{OrigZone: 2, DestZone: 2}
+	msgs := []FidoMessage{
+		{
+			FromName: "Alice", ToName: "Bob", Subj: "hi",
+			Text:     "AREA:FIDONEWS\n\x01MSGID: 2:1/1 12345678\nhello there\n",
+			DateTime: time.Date(2026, time.July, 27, 12, 0, 0, 0, time.UTC),
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := PktWrite(&buf, head, "", msgs, VariantType2, DefaultCharset); err != nil {
+		t.Fatalf("PktWrite: %v", err)
+	}
+
+	_, _, gotMsgs, err := PktRead(&buf)
+	if err != nil {
+		t.Fatalf("PktRead: %v", err)
+	}
+	if len(gotMsgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(gotMsgs))
+	}
+	kludges := GetKludges(gotMsgs[0].Text)
+	if kludges["AREA"] != "FIDONEWS" {
+		t.Errorf("AREA kludge = %q, want %q (Text was %q)", kludges["AREA"], "FIDONEWS", gotMsgs[0].Text)
+	}
+	if kludges["MSGID"] != "2:1/1 12345678" {
+		t.Errorf("MSGID kludge = %q, want %q", kludges["MSGID"], "2:1/1 12345678")
+	}
+}
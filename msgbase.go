@@ -0,0 +1,30 @@
+package gofido
+
+// MessageBase is the common contract for the classic FTN message storage
+// formats (JAM, Squish, *.msg). It lets a tosser append and retrieve
+// FidoMessage values without caring which on-disk format a particular echo
+// area or netmail area uses.
+type MessageBase interface {
+	// Open opens the message base at path, creating an empty one there if
+	// createNew is true and nothing exists yet.
+	Open(path string, createNew bool) error
+	// Close flushes any buffered writes and releases the underlying files.
+	Close() error
+	// Append adds msg to the end of the base and returns its message number.
+	Append(msg FidoMessage) (num uint32, err error)
+	// ReadByNum returns the message stored under num.
+	ReadByNum(num uint32) (FidoMessage, error)
+	// NumMessages returns how many messages (including any marked deleted)
+	// the base currently holds.
+	NumMessages() (uint32, error)
+	// Lock takes an exclusive lock on the base so other processes don't
+	// write to it concurrently. Callers must call Unlock when done.
+	Lock() error
+	// Unlock releases a lock taken by Lock.
+	Unlock() error
+	// LastRead returns the last-read message number the base has recorded
+	// for userName, or 0 if none is recorded.
+	LastRead(userName string) (uint32, error)
+	// SetLastRead records num as the last message userName has read.
+	SetLastRead(userName string, num uint32) error
+}
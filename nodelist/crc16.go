@@ -0,0 +1,36 @@
+package nodelist
+
+// crc16 incrementally computes the CRC-16/ARC checksum (poly 0xA001,
+// reflected, init 0) that FTS-5000 nodelist headers carry.
+type crc16 struct {
+	sumValue uint16
+}
+
+func newCRC16() *crc16 {
+	return &crc16{}
+}
+
+func (c *crc16) writeByte(b byte) {
+	c.sumValue ^= uint16(b)
+	for i := 0; i < 8; i++ {
+		if c.sumValue&1 != 0 {
+			c.sumValue = (c.sumValue >> 1) ^ 0xA001
+		} else {
+			c.sumValue >>= 1
+		}
+	}
+}
+
+// writeLine feeds line plus its CR/LF terminator into the checksum, as the
+// original nodelist file bytes would have been.
+func (c *crc16) writeLine(line string) {
+	for i := 0; i < len(line); i++ {
+		c.writeByte(line[i])
+	}
+	c.writeByte('\r')
+	c.writeByte('\n')
+}
+
+func (c *crc16) sum() uint16 {
+	return c.sumValue
+}
@@ -0,0 +1,66 @@
+package nodelist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/undead-ru/gofido"
+)
+
+// ApplyNodediff applies a .NDIFF update to base and returns the resulting
+// Nodelist, so callers can keep a nodelist current without redownloading
+// the full file. Lines in diff are ordinary nodelist data lines; a leading
+// "-" marks a line to remove, anything else is added (replacing any
+// existing entry at the same address).
+//
+// The returned Nodelist's HeaderCRC16/ComputedCRC16 are left zero: once a
+// diff has been applied there's no single source file left to check a
+// checksum against.
+func ApplyNodediff(base *Nodelist, diff io.Reader) (*Nodelist, error) {
+	result := &Nodelist{Entries: append([]NodelistEntry(nil), base.Entries...)}
+
+	var zone, net uint16
+	scanner := bufio.NewScanner(diff)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		remove := strings.HasPrefix(line, "-")
+		if remove {
+			line = line[1:]
+		}
+
+		entry, newZone, newNet, err := parseEntry(line, zone, net)
+		if err != nil {
+			return nil, fmt.Errorf("nodediff: %w", err)
+		}
+		zone, net = newZone, newNet
+
+		result.Entries = removeEntryAt(result.Entries, entry.Address)
+		if !remove {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("nodediff: reading diff: %w", err)
+	}
+
+	return result, nil
+}
+
+func removeEntryAt(entries []NodelistEntry, addr gofido.FidoNetAddress) []NodelistEntry {
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Address.Zone == addr.Zone && e.Address.Network == addr.Network && e.Address.Node == addr.Node {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
@@ -0,0 +1,44 @@
+package nodelist
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/undead-ru/gofido"
+)
+
+func TestApplyNodediffAddAndRemove(t *testing.T) {
+	base := &Nodelist{
+		Entries: []NodelistEntry{
+			{Address: gofido.FidoNetAddress{Zone: 2, Network: 1, Node: 1}, SystemName: "Old"},
+			{Address: gofido.FidoNetAddress{Zone: 2, Network: 1, Node: 2}, SystemName: "Untouched"},
+		},
+	}
+
+	diff := strings.Join([]string{
+		"Zone,2,Test_Zone,Testville,Sysop,1-234-567-8900,9600,CM",
+		"Host,1,Sub_Host,Hostville,Sysop,1-234-567-8900,9600,CM",
+		"-,1,Old,Oldville,Sysop,1-234-567-8900,9600,CM",
+		",3,New,Newville,Sysop,1-234-567-8901,9600,CM,IBN",
+	}, "\r\n") + "\r\n"
+
+	result, err := ApplyNodediff(base, strings.NewReader(diff))
+	if err != nil {
+		t.Fatalf("ApplyNodediff: %v", err)
+	}
+
+	if _, err := result.Lookup(gofido.FidoNetAddress{Zone: 2, Network: 1, Node: 1}); err == nil {
+		t.Error("removed node 1 is still present")
+	}
+	untouched, err := result.Lookup(gofido.FidoNetAddress{Zone: 2, Network: 1, Node: 2})
+	if err != nil || untouched.SystemName != "Untouched" {
+		t.Errorf("untouched node 2 = %+v, %v", untouched, err)
+	}
+	added, err := result.Lookup(gofido.FidoNetAddress{Zone: 2, Network: 1, Node: 3})
+	if err != nil {
+		t.Fatalf("added node 3 not found: %v", err)
+	}
+	if added.SystemName != "New" {
+		t.Errorf("added node 3 = %+v, want SystemName %q", added, "New")
+	}
+}
@@ -0,0 +1,195 @@
+// Package nodelist parses FTS-5000 (St. Louis format) FidoNet nodelists and
+// resolves addresses to sysop/system/BinkP information.
+package nodelist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/undead-ru/gofido"
+)
+
+// NodelistEntry is one data line of a nodelist.
+type NodelistEntry struct {
+	Keyword    string // "", "Zone", "Region", "Host", "Hub", "Pvt", "Hold" or "Down"
+	Address    gofido.FidoNetAddress
+	SystemName string
+	Location   string
+	Sysop      string
+	Phone      string
+	Baud       int
+	Flags      []string
+}
+
+// Nodelist is a parsed FTS-5000 nodelist.
+type Nodelist struct {
+	Entries []NodelistEntry
+
+	// HeaderCRC16 is the CRC-16 value claimed by the ";A" header line;
+	// ComputedCRC16 is what Parse actually computed over the data that
+	// follows it. They should match; compare with Valid.
+	HeaderCRC16   uint16
+	ComputedCRC16 uint16
+}
+
+// Valid reports whether the nodelist's header CRC-16 matches the CRC-16 of
+// the data Parse read.
+func (nl *Nodelist) Valid() bool {
+	return nl.HeaderCRC16 == nl.ComputedCRC16
+}
+
+// Lookup returns the entry for addr, or an error if no matching node is in
+// the nodelist.
+func (nl *Nodelist) Lookup(addr gofido.FidoNetAddress) (*NodelistEntry, error) {
+	for i := range nl.Entries {
+		e := &nl.Entries[i]
+		if e.Address.Zone == addr.Zone && e.Address.Network == addr.Network && e.Address.Node == addr.Node {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("nodelist: no entry for %s", gofido.ComposeAddress(addr))
+}
+
+// ResolveBinkP walks e's IBN/INA flags to produce a dialable BinkP
+// endpoint. A bare IFC flag doesn't count: it advertises ifcico, not
+// BinkP.
+func (e *NodelistEntry) ResolveBinkP() (host string, port int, err error) {
+	port = 24554
+	hasBinkP := false
+	for _, flag := range e.Flags {
+		switch {
+		case flag == "IBN":
+			hasBinkP = true
+		case strings.HasPrefix(flag, "IBN:"):
+			hasBinkP = true
+			if p, perr := strconv.Atoi(strings.TrimPrefix(flag, "IBN:")); perr == nil {
+				port = p
+			}
+		case strings.HasPrefix(flag, "INA:"):
+			host = strings.TrimPrefix(flag, "INA:")
+		}
+	}
+	if !hasBinkP {
+		return "", 0, fmt.Errorf("nodelist: %s advertises no IBN flag", gofido.ComposeAddress(e.Address))
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("nodelist: %s has no INA hostname", gofido.ComposeAddress(e.Address))
+	}
+	return host, port, nil
+}
+
+// ResolveBinkP looks addr up in nl and resolves a dialable BinkP endpoint
+// for it.
+func (nl *Nodelist) ResolveBinkP(addr gofido.FidoNetAddress) (host string, port int, err error) {
+	entry, err := nl.Lookup(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	return entry.ResolveBinkP()
+}
+
+// Parse reads a St. Louis format nodelist from r.
+func Parse(r io.Reader) (*Nodelist, error) {
+	nl := &Nodelist{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var zone, net uint16
+	crc := newCRC16()
+	headerSeen := false
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !headerSeen {
+			// FTS-5000's CRC-16 covers every byte following the ";A" header
+			// line through EOF, so nothing before it (including the header
+			// line itself) feeds the running checksum.
+			if strings.HasPrefix(line, ";A") {
+				nl.HeaderCRC16 = parseHeaderCRC(line)
+				headerSeen = true
+			}
+			continue
+		}
+
+		crc.writeLine(line)
+
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		entry, newZone, newNet, err := parseEntry(line, zone, net)
+		if err != nil {
+			return nil, err
+		}
+		zone, net = newZone, newNet
+		nl.Entries = append(nl.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("nodelist: reading nodelist: %w", err)
+	}
+
+	nl.ComputedCRC16 = crc.sum()
+	return nl, nil
+}
+
+// parseHeaderCRC pulls the trailing decimal CRC-16 value off a ";A" header
+// line, e.g. ";A FidoNet Nodelist for Monday, January 01, 2026 -- Day
+// number 001 : 52719".
+func parseHeaderCRC(line string) uint16 {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return 0
+	}
+	v, err := strconv.ParseUint(fields[len(fields)-1], 10, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(v)
+}
+
+// parseEntry parses one data line, using and possibly updating the current
+// zone/net addressing context.
+func parseEntry(line string, zone, net uint16) (entry NodelistEntry, newZone, newNet uint16, err error) {
+	fields := strings.Split(line, ",")
+	if len(fields) < 7 {
+		return entry, zone, net, fmt.Errorf("nodelist: malformed entry: %q", line)
+	}
+
+	num, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return entry, zone, net, fmt.Errorf("nodelist: bad node number in %q: %w", line, err)
+	}
+
+	keyword := fields[0]
+	switch keyword {
+	case "Zone":
+		zone = uint16(num)
+		net = uint16(num)
+		entry.Address = gofido.FidoNetAddress{Zone: zone, Network: net, Node: 0}
+	case "Region", "Host":
+		net = uint16(num)
+		entry.Address = gofido.FidoNetAddress{Zone: zone, Network: net, Node: 0}
+	default: // "", "Hub", "Pvt", "Hold", "Down"
+		entry.Address = gofido.FidoNetAddress{Zone: zone, Network: net, Node: uint16(num)}
+	}
+
+	entry.Keyword = keyword
+	entry.SystemName = unescapeField(fields[2])
+	entry.Location = unescapeField(fields[3])
+	entry.Sysop = unescapeField(fields[4])
+	entry.Phone = unescapeField(fields[5])
+	entry.Baud, _ = strconv.Atoi(fields[6])
+	if len(fields) > 7 {
+		entry.Flags = fields[7:]
+	}
+	return entry, zone, net, nil
+}
+
+// unescapeField turns the nodelist's "_" word separator back into a space.
+func unescapeField(s string) string {
+	return strings.Replace(s, "_", " ", -1)
+}
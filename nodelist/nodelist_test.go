@@ -0,0 +1,84 @@
+package nodelist
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func buildNodelist(t *testing.T, bodyLines []string) string {
+	t.Helper()
+	crc := newCRC16()
+	for _, line := range bodyLines {
+		crc.writeLine(line)
+	}
+	header := ";A Test Nodelist for Monday, January 01, 2026 -- Day number 001 : " + strconv.Itoa(int(crc.sum()))
+	return header + "\r\n" + strings.Join(bodyLines, "\r\n") + "\r\n"
+}
+
+func TestParseCRCAndEntries(t *testing.T) {
+	bodyLines := []string{
+		"",
+		";a comment line, which the CRC must still cover",
+		"Zone,1,Test_Zone,Testville,Sysop,1-234-567-8900,9600,CM,INA:host.example.com,IBN",
+	}
+	text := buildNodelist(t, bodyLines)
+
+	nl, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !nl.Valid() {
+		t.Fatalf("Valid() = false, header CRC %d, computed CRC %d", nl.HeaderCRC16, nl.ComputedCRC16)
+	}
+	if len(nl.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(nl.Entries))
+	}
+
+	entry := nl.Entries[0]
+	if entry.SystemName != "Test Zone" || entry.Location != "Testville" {
+		t.Errorf("entry = %+v", entry)
+	}
+
+	host, port, err := entry.ResolveBinkP()
+	if err != nil {
+		t.Fatalf("ResolveBinkP: %v", err)
+	}
+	if host != "host.example.com" || port != 24554 {
+		t.Errorf("ResolveBinkP = %s:%d, want host.example.com:24554", host, port)
+	}
+}
+
+func TestParseCRCCoversBlankAndCommentLines(t *testing.T) {
+	withoutExtra := buildNodelist(t, []string{
+		"Zone,1,Test_Zone,Testville,Sysop,1-234-567-8900,9600,CM,INA:host.example.com,IBN",
+	})
+	withExtra := buildNodelist(t, []string{
+		"",
+		";a comment",
+		"Zone,1,Test_Zone,Testville,Sysop,1-234-567-8900,9600,CM,INA:host.example.com,IBN",
+	})
+
+	nlWithout, err := Parse(strings.NewReader(withoutExtra))
+	if err != nil {
+		t.Fatalf("Parse (without extra lines): %v", err)
+	}
+	nlWithExtra, err := Parse(strings.NewReader(withExtra))
+	if err != nil {
+		t.Fatalf("Parse (with extra lines): %v", err)
+	}
+
+	if nlWithout.ComputedCRC16 == nlWithExtra.ComputedCRC16 {
+		t.Errorf("computed CRC didn't change when blank/comment lines were added, want it to cover every byte after the header")
+	}
+	if !nlWithout.Valid() || !nlWithExtra.Valid() {
+		t.Errorf("both nodelists should validate against their own embedded header CRC")
+	}
+}
+
+func TestResolveBinkPRejectsIFC(t *testing.T) {
+	entry := NodelistEntry{Flags: []string{"IFC", "INA:host.example.com"}}
+	if _, _, err := entry.ResolveBinkP(); err == nil {
+		t.Error("ResolveBinkP succeeded for an IFC-only entry, want an error: IFC is ifcico, not BinkP")
+	}
+}
@@ -0,0 +1,58 @@
+package squish
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/undead-ru/gofido"
+)
+
+func TestAppendReadByNumRoundTrip(t *testing.T) {
+	base := New()
+	if err := base.Open(filepath.Join(t.TempDir(), "test"), true); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer base.Close()
+
+	msg := gofido.FidoMessage{
+		FromName: "Alice",
+		ToName:   "Bob",
+		FromAddr: gofido.FidoNetAddress{Zone: 2, Network: 1, Node: 1},
+		ToAddr:   gofido.FidoNetAddress{Zone: 2, Network: 1, Node: 2},
+		Subj:     "hi",
+		Text:     "\x01MSGID: 2:1/1 12345678\n\x01PID: gofido 1.0\nhello there\n",
+		DateTime: time.Date(2026, time.July, 27, 12, 0, 0, 0, time.UTC),
+	}
+
+	num, err := base.Append(msg)
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	got, err := base.ReadByNum(num)
+	if err != nil {
+		t.Fatalf("ReadByNum: %v", err)
+	}
+
+	if got.FromName != msg.FromName || got.ToName != msg.ToName || got.Subj != msg.Subj {
+		t.Errorf("fields = %+v, want matching %+v", got, msg)
+	}
+	if got.FromAddr != msg.FromAddr || got.ToAddr != msg.ToAddr {
+		t.Errorf("addresses = from %+v, to %+v, want from %+v, to %+v", got.FromAddr, got.ToAddr, msg.FromAddr, msg.ToAddr)
+	}
+	if strings.TrimRight(gofido.GetMsgBody(got.Text), "\n") != strings.TrimRight(gofido.GetMsgBody(msg.Text), "\n") {
+		t.Errorf("body = %q, want %q", gofido.GetMsgBody(got.Text), gofido.GetMsgBody(msg.Text))
+	}
+	wantKludges := gofido.KludgeLines(msg.Text)
+	gotKludges := gofido.KludgeLines(got.Text)
+	if len(gotKludges) != len(wantKludges) {
+		t.Fatalf("kludge lines = %v, want %v", gotKludges, wantKludges)
+	}
+	for i, line := range wantKludges {
+		if gotKludges[i] != line {
+			t.Errorf("kludge line %d = %q, want %q", i, gotKludges[i], line)
+		}
+	}
+}